@@ -4,7 +4,9 @@
 package requestmod
 
 import (
+	"context"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"sync"
 )
@@ -14,6 +16,12 @@ import (
 // It must be safe to visit from multiple goroutines.
 type RequestVisitor func(req *http.Request) error
 
+// A ResponseVisitor is given the HTTP response to a request, optionally returning an error.
+// The function is allowed to inspect or modify the response, e.g. to record status codes,
+// detect auth expiration, or rewrite headers.
+// It must be safe to visit from multiple goroutines.
+type ResponseVisitor func(res *http.Response) error
+
 // A Transport wraps an existing http.RoundTripper, using the given RequestVisitor
 // on each request.
 type Transport struct {
@@ -23,15 +31,35 @@ type Transport struct {
 	// specific methods of the underlying RoundTripper.
 	Base http.RoundTripper
 
-	// RequestVisitor is called for each request.
-	// If nil, the request is sent untouched.
+	// RequestVisitor is called for each request, before any visitors in Visitors.
+	// If nil, it is skipped.
 	RequestVisitor RequestVisitor
 
-	mu sync.Mutex // for modReq
+	// Visitors is an ordered pipeline of RequestVisitors, run in turn on the cloned
+	// request after RequestVisitor. This lets callers compose independent concerns
+	// (auth header injection, tracing, user-agent rewrite, logging) without nesting
+	// wrapper transports. If any visitor returns an error, the chain stops and
+	// RoundTrip returns that error without calling Base.
+	Visitors []RequestVisitor
+
+	// ResponseVisitor is called with the response before it is returned to the caller.
+	// If nil, the response is returned untouched. If it returns an error, the response
+	// body is drained and closed, and the error is returned instead of the response.
+	ResponseVisitor ResponseVisitor
+
+	mu sync.Mutex // for modReq and Visitors
+
+	// modReq maps the original http.Request to the modified one along with the
+	// CancelFunc for its derived context, because RoundTrippers are not allowed to
+	// modify the original, yet we need to keep track of it for CancelRequest.
+	modReq map[*http.Request]modRequest
+}
 
-	// modReq maps the original http.Request to the modified one, because
-	// RoundTrippers are not allowed to modify the original, yet we need to keep track of it.
-	modReq map[*http.Request]*http.Request
+// A modRequest pairs a cloned, in-flight request with the CancelFunc for the
+// context it was derived with.
+type modRequest struct {
+	req    *http.Request
+	cancel context.CancelFunc
 }
 
 // NewTransport creates a Transport with the given RoundTripper and RequestVisitor.
@@ -43,65 +71,140 @@ func NewTransport(Base http.RoundTripper, RequestVisitor RequestVisitor) http.Ro
 	return &Transport{
 		Base:           Base,
 		RequestVisitor: RequestVisitor,
-		modReq:         make(map[*http.Request]*http.Request),
+		modReq:         make(map[*http.Request]modRequest),
 	}
 }
 
+// NewTransportChain creates a Transport with the given RoundTripper and an ordered
+// pipeline of RequestVisitors. If base is nil, http.DefaultTransport is used instead.
+func NewTransportChain(base http.RoundTripper, visitors ...RequestVisitor) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{
+		Base:     base,
+		Visitors: visitors,
+		modReq:   make(map[*http.Request]modRequest),
+	}
+}
+
+// AppendVisitor adds visitor to the end of the pipeline.
+func (t *Transport) AppendVisitor(visitor RequestVisitor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Visitors = append(t.Visitors, visitor)
+}
+
+// PrependVisitor adds visitor to the beginning of the pipeline.
+func (t *Transport) PrependVisitor(visitor RequestVisitor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Visitors = append([]RequestVisitor{visitor}, t.Visitors...)
+}
+
 // RoundTrip implements the RoundTripper interface.
 // It will apply each modifier to the request.
 // It will return an error if any modifier returned an error.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBodyClosed := false
+	if req.Body != nil {
+		defer func() {
+			if !reqBodyClosed {
+				req.Body.Close()
+			}
+		}()
+	}
+
 	mod := cloneRequest(req)
+	ctx, cancel := context.WithCancel(req.Context())
+	mod = mod.WithContext(ctx)
 
 	if t.RequestVisitor != nil {
 		err := t.RequestVisitor(mod)
 		if err != nil {
+			cancel()
 			return nil, err
 		}
 	}
 
-	t.setModReq(req, mod)
+	t.mu.Lock()
+	visitors := t.Visitors
+	t.mu.Unlock()
+	for _, visit := range visitors {
+		if err := visit(mod); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	t.setModReq(req, mod, cancel)
+	reqBodyClosed = true
 	res, err := t.Base.RoundTrip(mod)
 
 	if err != nil {
-		t.setModReq(req, nil)
+		cancel()
+		t.setModReq(req, nil, nil)
 		return nil, err
 	}
+
+	if t.ResponseVisitor != nil {
+		if err := t.ResponseVisitor(res); err != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			cancel()
+			t.setModReq(req, nil, nil)
+			return nil, err
+		}
+	}
+
 	res.Body = &onEOFReader{
 		rc: res.Body,
-		fn: func() { t.setModReq(req, nil) },
+		fn: func() {
+			cancel()
+			t.setModReq(req, nil, nil)
+		},
 	}
 	return res, nil
 }
 
-// CancelRequest cancels an in-flight request by closing its connection.
-// This will only work if the base transport supports canceling requests.
+// CancelRequest cancels an in-flight request by cancelling the context its clone
+// was derived with, which the base transport is expected to observe via
+// Request.Context(). The legacy canceler interface this used to rely on is
+// deprecated in net/http in favor of context-driven cancellation.
+//
+// Deprecated: callers should cancel in-flight requests through the request's own
+// context instead of calling this method directly.
 func (t *Transport) CancelRequest(req *http.Request) {
-	type canceler interface {
-		CancelRequest(*http.Request)
-	}
-	if cr, ok := t.Base.(canceler); ok {
-		t.mu.Lock()
-		modReq := t.modReq[req]
-		delete(t.modReq, req)
-		t.mu.Unlock()
-		cr.CancelRequest(modReq)
+	t.mu.Lock()
+	entry, ok := t.modReq[req]
+	delete(t.modReq, req)
+	t.mu.Unlock()
+
+	if ok && entry.cancel != nil {
+		entry.cancel()
 	}
 }
 
-// setModReq updates the map mapping original requests to their modified versions.
-func (t *Transport) setModReq(orig, mod *http.Request) {
+// setModReq updates the map mapping original requests to their in-flight clones
+// and the CancelFunc for the context each clone was derived with.
+func (t *Transport) setModReq(orig, mod *http.Request, cancel context.CancelFunc) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	if mod == nil {
 		delete(t.modReq, orig)
 	} else {
-		t.modReq[orig] = mod
+		t.modReq[orig] = modRequest{req: mod, cancel: cancel}
 	}
 }
 
-// cloneRequest creates a clone of the given request, copying over header values.
+// cloneRequest creates a clone of the given request, copying over header and
+// trailer values. Per the oauth2 pattern, mod.Body is left as the same
+// ReadCloser as orig.Body: the base RoundTripper takes ownership of it and
+// closes it when it's done, which is what satisfies the "close req.Body
+// exactly once" half of the RoundTripper contract. Callers that need to
+// replay the body (e.g. retryingvisitor.go) should rewind it themselves via
+// orig.GetBody.
 func cloneRequest(orig *http.Request) *http.Request {
 	mod := new(http.Request)
 	*mod = *orig
@@ -109,6 +212,12 @@ func cloneRequest(orig *http.Request) *http.Request {
 	for k, s := range orig.Header {
 		mod.Header[k] = append([]string(nil), s...)
 	}
+	if orig.Trailer != nil {
+		mod.Trailer = make(http.Header, len(orig.Trailer))
+		for k, s := range orig.Trailer {
+			mod.Trailer[k] = append([]string(nil), s...)
+		}
+	}
 	return mod
 }
 
@@ -7,11 +7,24 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 type verifier func(*http.Request)
 
+// a countingBody is an io.ReadCloser that records how many times Close was called.
+type countingBody struct {
+	io.Reader
+	closes int
+}
+
+func (b *countingBody) Close() error {
+	b.closes++
+	return nil
+}
+
 type errorTransport struct{}
 
 func (*errorTransport) RoundTrip(*http.Request) (*http.Response, error) {
@@ -74,6 +87,89 @@ func TestModError(t *testing.T) {
 	}
 }
 
+func TestBodyClosedOnModError(t *testing.T) {
+	cli := makeClient(nil, errorMod)
+
+	body := &countingBody{Reader: strings.NewReader("this is a test")}
+	req := &http.Request{URL: &url.URL{}, Header: make(http.Header), Body: body}
+
+	cli.Do(req)
+
+	if body.closes != 1 {
+		t.Errorf("expected request body to be closed exactly once, got %v", body.closes)
+	}
+}
+
+func TestBodyClosedOnVisitorChainError(t *testing.T) {
+	trans := NewTransportChain(nil, func(mod *http.Request) error { return nil }, errorMod)
+	cli := &http.Client{Transport: trans}
+
+	body := &countingBody{Reader: strings.NewReader("this is a test")}
+	req := &http.Request{URL: &url.URL{}, Header: make(http.Header), Body: body}
+
+	cli.Do(req)
+
+	if body.closes != 1 {
+		t.Errorf("expected request body to be closed exactly once, got %v", body.closes)
+	}
+}
+
+func TestBodyClosedOnSuccess(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	cli := makeClient(nil, nil)
+
+	u, _ := url.Parse(ts.URL)
+	body := &countingBody{Reader: strings.NewReader("this is a test")}
+	req := &http.Request{Method: "POST", URL: u, Header: make(http.Header), Body: body}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if body.closes != 1 {
+		t.Errorf("expected request body to be closed exactly once, got %v", body.closes)
+	}
+}
+
+func TestBodyClosedOnSuccessWithGetBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	cli := makeClient(nil, nil)
+
+	u, _ := url.Parse(ts.URL)
+	body := &countingBody{Reader: strings.NewReader("this is a test")}
+	req := &http.Request{
+		Method: "POST",
+		URL:    u,
+		Header: make(http.Header),
+		Body:   body,
+		GetBody: func() (io.ReadCloser, error) {
+			return &countingBody{Reader: strings.NewReader("this is a test")}, nil
+		},
+	}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if body.closes != 1 {
+		t.Errorf("expected the original request body to be closed exactly once, got %v", body.closes)
+	}
+}
+
 func TestBaseError(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	}))
@@ -88,24 +184,161 @@ func TestBaseError(t *testing.T) {
 	}
 }
 
+func TestVisitorChain(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-First") != "1" || r.Header.Get("X-Second") != "2" {
+			t.Errorf("Visitors did not run in order: first=%v second=%v", r.Header.Get("X-First"), r.Header.Get("X-Second"))
+		}
+	}))
+	defer ts.Close()
+
+	trans := NewTransportChain(nil, func(mod *http.Request) error {
+		mod.Header.Set("X-First", "1")
+		return nil
+	}, func(mod *http.Request) error {
+		mod.Header.Set("X-Second", "2")
+		return nil
+	})
+	cli := &http.Client{Transport: trans}
+
+	resp, err := cli.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+}
+
+func TestVisitorChainError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Base should not have been called")
+	}))
+	defer ts.Close()
+
+	trans := NewTransportChain(nil, func(mod *http.Request) error {
+		return nil
+	}, errorMod)
+	cli := &http.Client{Transport: trans}
+
+	if _, err := cli.Get(ts.URL); err == nil {
+		t.Error("Did *not* get an expected chain error.")
+	}
+}
+
+func TestAppendPrependVisitor(t *testing.T) {
+	var order []string
+
+	trans := NewTransportChain(nil).(*Transport)
+	trans.AppendVisitor(func(mod *http.Request) error {
+		order = append(order, "second")
+		return nil
+	})
+	trans.PrependVisitor(func(mod *http.Request) error {
+		order = append(order, "first")
+		return nil
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	cli := &http.Client{Transport: trans}
+	resp, err := cli.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("expected [first second], got %v", order)
+	}
+}
+
+func TestResponseVisitor(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Test succeeded")
+	}))
+	defer ts.Close()
+
+	var seenStatus int
+	trans := NewTransport(nil, nil).(*Transport)
+	trans.ResponseVisitor = func(res *http.Response) error {
+		seenStatus = res.StatusCode
+		res.Header.Set("X-Visited", "yes")
+		return nil
+	}
+	cli := &http.Client{Transport: trans}
+
+	resp, err := cli.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if seenStatus != 200 {
+		t.Errorf("expected ResponseVisitor to see status 200, got %v", seenStatus)
+	}
+	if resp.Header.Get("X-Visited") != "yes" {
+		t.Error("expected ResponseVisitor's header mutation to be visible on the response")
+	}
+}
+
+func TestResponseVisitorError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Test succeeded")
+	}))
+	defer ts.Close()
+
+	trans := NewTransport(nil, nil).(*Transport)
+	trans.ResponseVisitor = func(res *http.Response) error {
+		return errors.New("stale auth")
+	}
+	cli := &http.Client{Transport: trans}
+
+	if _, err := cli.Get(ts.URL); err == nil {
+		t.Error("Did *not* get an expected response visitor error.")
+	}
+}
+
 func TestCancel(t *testing.T) {
+	handlerStarted := make(chan struct{})
+	blockForever := make(chan struct{})
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(handlerStarted)
+		<-blockForever
 	}))
 	defer ts.Close()
+	defer close(blockForever)
 
 	cli := makeClient(nil, nil)
 
-	url, _ := url.Parse(ts.URL)
+	u, _ := url.Parse(ts.URL)
+	req, _ := http.NewRequest("GET", u.String(), nil)
 
-	req := http.Request{
-		URL: url,
-	}
-	cli.Do(&req)
 	type canceler interface {
 		CancelRequest(*http.Request)
 	}
-	if cr, ok := cli.Transport.(canceler); ok {
-		cr.CancelRequest(&req)
+	cr, ok := cli.Transport.(canceler)
+	if !ok {
+		t.Fatal("Transport does not implement CancelRequest")
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := cli.Do(req)
+		errCh <- err
+	}()
+
+	<-handlerStarted
+	cr.CancelRequest(req)
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("expected CancelRequest to abort the in-flight RoundTrip with an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RoundTrip did not return after CancelRequest")
 	}
-	// TODO: how to verify it was actually cancelled?
 }
@@ -0,0 +1,62 @@
+package requestmod
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// NewRetryingVisitor returns an http.RoundTripper that applies visitor to each
+// request. If check reports that the response indicates visitor's state has gone
+// stale (e.g. a 401 after an auth header was set), refresh is called to update that
+// state, the request body is rewound via req.GetBody, and the request is re-issued
+// exactly once with the refreshed visitor. If req.GetBody is nil, the body is not
+// rewound: the retry is sent with req.Body as the first attempt left it.
+//
+// This mirrors how golang.org/x/oauth2's Transport retries after refreshing a
+// TokenSource, letting requestmod serve as a reusable foundation for OAuth2-style
+// token refresh without hardcoding OAuth.
+func NewRetryingVisitor(check func(*http.Response) bool, refresh func(context.Context) error, visitor RequestVisitor) http.RoundTripper {
+	return &retryingTransport{
+		trans:   NewTransport(nil, visitor).(*Transport),
+		check:   check,
+		refresh: refresh,
+	}
+}
+
+// A retryingTransport drives a Transport, retrying the request exactly once after
+// refresh is called when check indicates the visitor's state has gone stale.
+type retryingTransport struct {
+	trans   *Transport
+	check   func(*http.Response) bool
+	refresh func(context.Context) error
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (t *retryingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.trans.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if !t.check(res) {
+		return res, nil
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	if err := t.refresh(req.Context()); err != nil {
+		return nil, err
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+
+	return t.trans.RoundTrip(req)
+}
@@ -0,0 +1,107 @@
+package requestmod
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRetryingVisitor(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Token") != "fresh" || string(body) != "payload" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	token := "stale"
+	var refreshed bool
+
+	trans := NewRetryingVisitor(
+		func(res *http.Response) bool { return res.StatusCode == http.StatusUnauthorized },
+		func(ctx context.Context) error {
+			refreshed = true
+			token = "fresh"
+			return nil
+		},
+		func(req *http.Request) error {
+			req.Header.Set("X-Token", token)
+			return nil
+		},
+	)
+	cli := &http.Client{Transport: trans}
+
+	req, _ := http.NewRequest("POST", ts.URL, bytes.NewReader([]byte("payload")))
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if !refreshed {
+		t.Error("expected refresh to be called after a 401")
+	}
+	if requests != 2 {
+		t.Errorf("expected exactly one retry (2 requests), got %v", requests)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the retried request to succeed, got status %v", resp.StatusCode)
+	}
+}
+
+func TestRetryingVisitorNoRetryWhenFresh(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	trans := NewRetryingVisitor(
+		func(res *http.Response) bool { return res.StatusCode == http.StatusUnauthorized },
+		func(ctx context.Context) error {
+			t.Error("refresh should not be called when the response is not stale")
+			return nil
+		},
+		func(req *http.Request) error { return nil },
+	)
+	cli := &http.Client{Transport: trans}
+
+	resp, err := cli.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if requests != 1 {
+		t.Errorf("expected exactly one request, got %v", requests)
+	}
+}
+
+func TestRetryingVisitorRefreshError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	trans := NewRetryingVisitor(
+		func(res *http.Response) bool { return res.StatusCode == http.StatusUnauthorized },
+		func(ctx context.Context) error { return errors.New("refresh failed") },
+		func(req *http.Request) error { return nil },
+	)
+	cli := &http.Client{Transport: trans}
+
+	if _, err := cli.Get(ts.URL); err == nil {
+		t.Error("Did *not* get an expected refresh error.")
+	}
+}